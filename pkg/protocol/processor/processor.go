@@ -1,10 +1,13 @@
 package processor
 
 import (
+	"context"
 	"errors"
+	"sync"
 	"time"
 
 	"github.com/iotaledger/hive.go/events"
+	"github.com/iotaledger/hive.go/logger"
 	"github.com/iotaledger/hive.go/objectstorage"
 	"github.com/iotaledger/hive.go/workerpool"
 
@@ -19,6 +22,7 @@ import (
 	"github.com/gohornet/hornet/pkg/protocol/bqueue"
 	"github.com/gohornet/hornet/pkg/protocol/message"
 	"github.com/gohornet/hornet/pkg/protocol/rqueue"
+	"github.com/gohornet/hornet/pkg/protocol/snap"
 	"github.com/gohornet/hornet/pkg/protocol/sting"
 	"github.com/gohornet/hornet/plugins/curl"
 )
@@ -30,6 +34,8 @@ const (
 var (
 	workerCount         = 64
 	ErrInvalidTimestamp = errors.New("invalid timestamp")
+
+	log = logger.NewLogger("Processor")
 )
 
 // New creates a new processor which parses messages.
@@ -41,8 +47,11 @@ func New(requestQueue rqueue.Queue, peerManager *peering.Manager, opts *Options)
 			MessageProcessed: events.NewEvent(MessageProcessedCaller),
 			BroadcastMessage: events.NewEvent(BroadcastCaller),
 		},
-		opts: *opts,
+		opts:        *opts,
+		peerCtxs:    make(map[string]*peerContext),
+		snapReplies: make(map[string][]chan []byte),
 	}
+	proc.shutdownCtx, proc.shutdownCancel = context.WithCancel(context.Background())
 	wuCacheOpts := opts.WorkUnitCacheOpts
 	proc.workUnits = objectstorage.New(
 		nil,
@@ -60,20 +69,41 @@ func New(requestQueue rqueue.Queue, peerManager *peering.Manager, opts *Options)
 
 	proc.wp = workerpool.New(func(task workerpool.Task) {
 		p := task.Param(0).(*peer.Peer)
-		data := task.Param(2).([]byte)
-
-		switch task.Param(1).(message.Type) {
+		ctx := task.Param(1).(context.Context)
+		msgType := task.Param(2).(message.Type)
+		data := task.Param(3).([]byte)
+
+		// dispatch on the message type itself: each type, whether allocated to sting or snap,
+		// maps to exactly one handler below. A numeric range check over the two protocols'
+		// allocated ids would be a brittle proxy for this and break the moment either protocol's
+		// message types are renumbered or grow past the other's starting id.
+		switch msgType {
 		case sting.MessageTypeTransaction:
-			proc.processTransaction(p, data)
+			proc.processTransaction(ctx, p, data)
 		case sting.MessageTypeTransactionRequest:
 			proc.processMessageRequest(p, data)
 		case sting.MessageTypeMilestoneRequest:
 			proc.processMilestoneRequest(p, data)
+		case snap.MessageTypeGetSnapshotManifest:
+			proc.processGetSnapshotManifest(p, data)
+		case snap.MessageTypeGetAddressRange:
+			proc.processGetAddressRange(p, data)
+		case snap.MessageTypeGetMilestoneDiffs:
+			proc.processGetMilestoneDiffs(p, data)
+		case snap.MessageTypeSnapshotManifest, snap.MessageTypeAddressRange, snap.MessageTypeMilestoneDiffs:
+			proc.deliverSnapReply(p, data)
 		}
 
 		task.Return(nil)
 	}, workerpool.WorkerCount(workerCount), workerpool.QueueSize(WorkerQueueSize))
 
+	// a peer can also disconnect on its own (timeout, remote close, etc.) without this processor
+	// ever deciding to drop it via removePeer; tear down its context in that case too, so
+	// in-flight WorkUnits whose only receiver was that peer still abort their processing.
+	peerManager.Events.PeerDisconnected.Attach(events.NewClosure(func(p *peer.Peer) {
+		proc.cancelPeerContext(p)
+	}))
+
 	return proc
 }
 
@@ -101,6 +131,31 @@ type Processor struct {
 	requestQueue rqueue.Queue
 	workUnits    *objectstorage.ObjectStorage
 	opts         Options
+
+	// shutdownCtx is cancelled once Run's shutdown signal fires, tearing down every peer context
+	// derived from it and, in turn, every WorkUnit context whose only receivers were those peers.
+	shutdownCtx    context.Context
+	shutdownCancel context.CancelFunc
+
+	peerCtxMu sync.Mutex
+	peerCtxs  map[string]*peerContext
+
+	// addressDigestMu guards the memoized address-range digest below.
+	addressDigestMu    sync.Mutex
+	addressDigestValid bool
+	addressDigestAt    uint32
+	addressDigestValue [32]byte
+
+	// snapReplyMu guards the per-peer FIFO queues of pending snap protocol reply channels below.
+	snapReplyMu sync.Mutex
+	snapReplies map[string][]chan []byte
+}
+
+// peerContext bundles the context derived for a single peer together with the cancel func
+// needed to tear it down once the peer is removed.
+type peerContext struct {
+	ctx    context.Context
+	cancel context.CancelFunc
 }
 
 // The Options for the Processor.
@@ -113,12 +168,59 @@ type Options struct {
 func (proc *Processor) Run(shutdownSignal <-chan struct{}) {
 	proc.wp.Start()
 	<-shutdownSignal
+
+	// cancel every peer (and therefore WorkUnit) context still outstanding, so in-flight
+	// hashing/validation can abandon expensive work instead of running it to completion for a
+	// processor that is already shutting down.
+	proc.shutdownCancel()
+
 	proc.wp.StopAndWait()
 }
 
-// Process submits the given message to the processor for processing.
+// Process submits the given message to the processor for processing. The message is processed
+// under a context tied to the submitting peer's lifetime: it is cancelled once that peer is
+// removed (see removePeer) or the processor shuts down, whichever happens first.
 func (proc *Processor) Process(p *peer.Peer, msgType message.Type, data []byte) {
-	proc.wp.Submit(p, msgType, data)
+	proc.wp.Submit(p, proc.contextFor(p), msgType, data)
+}
+
+// contextFor returns the context associated with the given peer, creating one derived from the
+// processor's shutdown context if this is the first time the peer is seen.
+func (proc *Processor) contextFor(p *peer.Peer) context.Context {
+	proc.peerCtxMu.Lock()
+	defer proc.peerCtxMu.Unlock()
+
+	pc, has := proc.peerCtxs[p.ID]
+	if !has {
+		ctx, cancel := context.WithCancel(proc.shutdownCtx)
+		pc = &peerContext{ctx: ctx, cancel: cancel}
+		proc.peerCtxs[p.ID] = pc
+	}
+
+	return pc.ctx
+}
+
+// removePeer drops the connection to the given peer and cancels its context, so that any
+// in-flight WorkUnits whose only receiver was this peer can abort their processing. This is for
+// the protocol-violation paths where this processor itself decides to drop a peer; an ordinary
+// disconnect initiated by the peer or the transport is handled by the PeerDisconnected subscription
+// in New, which calls cancelPeerContext directly since pm.Remove has already run by then.
+func (proc *Processor) removePeer(p *peer.Peer) {
+	proc.cancelPeerContext(p)
+	proc.pm.Remove(p.ID)
+}
+
+// cancelPeerContext cancels the context associated with the given peer, so that any in-flight
+// WorkUnits whose only receiver was this peer can abort their processing. Safe to call more than
+// once for the same peer.
+func (proc *Processor) cancelPeerContext(p *peer.Peer) {
+	proc.peerCtxMu.Lock()
+	defer proc.peerCtxMu.Unlock()
+
+	if pc, has := proc.peerCtxs[p.ID]; has {
+		pc.cancel()
+		delete(proc.peerCtxs, p.ID)
+	}
 }
 
 // SerializeAndEmit serializes the given message and emits TransactionProcessed and BroadcastTransaction events.
@@ -156,7 +258,7 @@ func (proc *Processor) processMilestoneRequest(p *peer.Peer, data []byte) {
 		metrics.SharedServerMetrics.InvalidRequests.Inc()
 
 		// drop the connection to the peer
-		proc.pm.Remove(p.ID)
+		proc.removePeer(p)
 		return
 	}
 
@@ -197,12 +299,174 @@ func (proc *Processor) processMessageRequest(p *peer.Peer, data []byte) {
 	p.EnqueueForSending(transactionMsg)
 }
 
+// processes a GetSnapshotManifest request by replying with the manifest of the snapshot
+// this node currently offers, so the peer can decide whether to snap-sync against it.
+func (proc *Processor) processGetSnapshotManifest(p *peer.Peer, _ []byte) {
+	snapshotInfo := tangle.GetSnapshotInfo()
+	if snapshotInfo == nil {
+		// we don't have a snapshot to offer yet
+		return
+	}
+
+	manifest := &snap.SnapshotManifest{
+		PruningIndex:        uint32(snapshotInfo.PruningIndex),
+		SolidEntryPoints:    tangle.GetAllSolidEntryPointHashes(),
+		LedgerDiffRangeFrom: uint32(snapshotInfo.PruningIndex) + 1,
+		LedgerDiffRangeTo:   uint32(snapshotInfo.SnapshotIndex),
+		Digest:              proc.addressRangeDigest(uint32(snapshotInfo.SnapshotIndex)),
+	}
+
+	manifestMsg, err := snap.NewSnapshotManifestMessage(manifest)
+	if err != nil {
+		return
+	}
+	p.EnqueueForSending(manifestMsg)
+}
+
+// addressRangeDigest returns the digest over this node's full address range for the given
+// snapshot index, memoized so that the (potentially large) range walk only runs once per new
+// snapshot index rather than on every single incoming GetSnapshotManifest request - otherwise any
+// peer could force a full ledger rescan on the shared worker pool just by repeatedly requesting
+// the manifest. The lock is held across the walk itself (not just the cache read/write) so that
+// concurrent requests for the same new snapshot index block on, rather than duplicate, it.
+func (proc *Processor) addressRangeDigest(snapshotIndex uint32) [32]byte {
+	proc.addressDigestMu.Lock()
+	defer proc.addressDigestMu.Unlock()
+
+	if proc.addressDigestValid && proc.addressDigestAt == snapshotIndex {
+		return proc.addressDigestValue
+	}
+
+	digest := computeAddressRangeDigest()
+
+	proc.addressDigestValid = true
+	proc.addressDigestAt = snapshotIndex
+	proc.addressDigestValue = digest
+
+	return digest
+}
+
+// computeAddressRangeDigest folds every address-balance chunk of this node's full address range
+// into a single digest, in the same chunk order and with the same algorithm SnapSyncer uses while
+// pulling those chunks, so a syncing peer's re-derived digest matches iff it received everything
+// intact.
+func computeAddressRangeDigest() [32]byte {
+	var digest [32]byte
+	var from []byte
+
+	for {
+		balances, exhausted := tangle.GetBalancesForAddressRange(from, nil, snap.MaxAddressesPerRange)
+		if len(balances) == 0 {
+			return digest
+		}
+
+		addresses := make([]snap.AddressBalance, len(balances))
+		for i, balance := range balances {
+			addresses[i] = snap.AddressBalance{Address: balance.Address, Balance: balance.Balance}
+		}
+		digest = snap.FoldAddressChunkDigest(digest, addresses)
+
+		if exhausted {
+			return digest
+		}
+		from = snap.NextAddress(addresses[len(addresses)-1].Address)
+	}
+}
+
+// processes a GetAddressRange request by replying with the balances of the addresses in the
+// requested lexicographic range, capped at snap.MaxAddressesPerRange entries.
+func (proc *Processor) processGetAddressRange(p *peer.Peer, data []byte) {
+	req, err := snap.ParseGetAddressRangeRequest(data)
+	if err != nil {
+		metrics.SharedServerMetrics.InvalidRequests.Inc()
+		proc.removePeer(p)
+		return
+	}
+
+	balances, exhausted := tangle.GetBalancesForAddressRange(req.From, req.To, snap.MaxAddressesPerRange)
+
+	addresses := make([]snap.AddressBalance, len(balances))
+	for i, balance := range balances {
+		addresses[i] = snap.AddressBalance{Address: balance.Address, Balance: balance.Balance}
+	}
+
+	rangeMsg, err := snap.NewAddressRangeMessage(&snap.AddressRangeResponse{Addresses: addresses, Exhausted: exhausted})
+	if err != nil {
+		return
+	}
+	p.EnqueueForSending(rangeMsg)
+}
+
+// processes a GetMilestoneDiffs request by streaming one MilestoneDiffs message per milestone
+// in the requested range back to the peer, followed by a snap.MilestoneDiffsTerminator message
+// so the requester (which may have asked for fewer diffs than it gets, if this node no longer
+// has the tail of the range) knows the stream for this request is done.
+func (proc *Processor) processGetMilestoneDiffs(p *peer.Peer, data []byte) {
+	req, err := snap.ParseGetMilestoneDiffsRequest(data)
+	if err != nil {
+		metrics.SharedServerMetrics.InvalidRequests.Inc()
+		proc.removePeer(p)
+		return
+	}
+
+	for msIndex := req.From; msIndex <= req.To; msIndex++ {
+		diffBytes, err := tangle.GetLedgerDiffBytesForMilestone(msIndex)
+		if err != nil {
+			// we don't have this part of the range (anymore), stop streaming
+			break
+		}
+
+		diffMsg, err := snap.NewMilestoneDiffMessage(&snap.MilestoneDiffResponse{MilestoneIndex: msIndex, Diff: diffBytes})
+		if err != nil {
+			continue
+		}
+		p.EnqueueForSending(diffMsg)
+	}
+
+	terminatorMsg, err := snap.NewMilestoneDiffMessage(&snap.MilestoneDiffResponse{MilestoneIndex: snap.MilestoneDiffsTerminator})
+	if err != nil {
+		return
+	}
+	p.EnqueueForSending(terminatorMsg)
+}
+
+// AwaitSnapReply registers this processor's interest in the next snap protocol reply from p and
+// returns the channel it will be delivered on. Callers must register before sending the request
+// that reply answers, and must register once per expected reply (GetMilestoneDiffs expects a
+// MilestoneDiffs message per milestone plus a final terminator, so it registers once per message).
+// Replies from a given peer are delivered in the order they were registered, which only matches
+// the order requests were answered in because a peer answers snap requests one at a time.
+func (proc *Processor) AwaitSnapReply(p *peer.Peer) <-chan []byte {
+	ch := make(chan []byte, 1)
+	proc.snapReplyMu.Lock()
+	proc.snapReplies[p.ID] = append(proc.snapReplies[p.ID], ch)
+	proc.snapReplyMu.Unlock()
+	return ch
+}
+
+// deliverSnapReply hands data to the oldest still-pending AwaitSnapReply channel registered for
+// p, if any. A reply with no pending registration (a peer replying to a request nobody is
+// waiting for anymore, e.g. after a timeout) is dropped.
+func (proc *Processor) deliverSnapReply(p *peer.Peer, data []byte) {
+	proc.snapReplyMu.Lock()
+	queue := proc.snapReplies[p.ID]
+	if len(queue) == 0 {
+		proc.snapReplyMu.Unlock()
+		return
+	}
+	ch := queue[0]
+	proc.snapReplies[p.ID] = queue[1:]
+	proc.snapReplyMu.Unlock()
+
+	ch <- data
+}
+
 // gets or creates a new WorkUnit for the given transaction and then processes the WorkUnit.
-func (proc *Processor) processTransaction(p *peer.Peer, data []byte) {
+func (proc *Processor) processTransaction(ctx context.Context, p *peer.Peer, data []byte) {
 	cachedWorkUnit := proc.workUnitFor(data) // workUnit +1
 	defer cachedWorkUnit.Release()           // workUnit -1
 	workUnit := cachedWorkUnit.WorkUnit()
-	workUnit.addReceivedFrom(p, nil)
+	workUnit.addReceivedFrom(p, ctx)
 	proc.processWorkUnit(workUnit, p)
 }
 
@@ -222,8 +486,12 @@ func (proc *Processor) processWorkUnit(wu *WorkUnit, p *peer.Peer) {
 
 		metrics.SharedServerMetrics.InvalidTransactions.Inc()
 
+		if err := wu.Error(); err != nil {
+			log.Warnf("dropping peer %s, cached error for known-invalid tx: %v", p.ID, err)
+		}
+
 		// drop the connection to the peer
-		proc.pm.Remove(p.ID)
+		proc.removePeer(p)
 
 		return
 	case wu.Is(Hashed):
@@ -247,9 +515,20 @@ func (proc *Processor) processWorkUnit(wu *WorkUnit, p *peer.Peer) {
 	wu.UpdateState(Hashing)
 	wu.processingLock.Unlock()
 
+	if err := wu.Context().Err(); err != nil {
+		// every receiver of this WorkUnit (this peer included) dropped before we got to the
+		// expensive part; bail out instead of hashing/validating for nobody. Aborted is not
+		// matched by the Is(Hashing) check above, so a future resubmission (e.g. the peer
+		// reconnects, or a different peer relays the same bytes) retries from scratch instead of
+		// being dropped for this WorkUnit's entire cache lifetime.
+		wu.UpdateState(Aborted)
+		return
+	}
+
 	// build Hornet representation of the message
 	msg, err := tangle.MessageFromBytes(wu.receivedMsgBytes, iotago.DeSeriModePerformValidation)
 	if err != nil {
+		wu.SetError(err)
 		wu.UpdateState(Invalid)
 		wu.punish()
 		return
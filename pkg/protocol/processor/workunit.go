@@ -0,0 +1,254 @@
+package processor
+
+import (
+	"context"
+	"crypto/sha256"
+	"sync"
+
+	"github.com/iotaledger/hive.go/objectstorage"
+
+	"github.com/gohornet/hornet/pkg/model/hornet"
+	"github.com/gohornet/hornet/pkg/model/tangle"
+	"github.com/gohornet/hornet/pkg/peering/peer"
+	"github.com/gohornet/hornet/pkg/protocol/bqueue"
+)
+
+// WorkUnitState reflects the state a WorkUnit is in.
+type WorkUnitState byte
+
+const (
+	// Received is the zero-value state of a freshly created WorkUnit: it has been received but
+	// processing hasn't started yet. It is deliberately kept out of the processWorkUnit switch's
+	// "already in progress"/"done" cases, the same way Aborted is, so a brand new WorkUnit falls
+	// through to actually being hashed/validated instead of being mistaken for one already being
+	// processed.
+	Received WorkUnitState = iota
+	// Hashing means the WorkUnit's underlying transaction is currently being hashed/validated.
+	Hashing
+	// Hashed means the WorkUnit's underlying transaction was successfully hashed/validated.
+	Hashed
+	// Invalid means the WorkUnit's underlying transaction is invalid.
+	Invalid
+	// Aborted means hashing/validation was abandoned because every receiver of the WorkUnit was
+	// gone before it completed. Unlike Hashing, it is not treated as "already in progress", so a
+	// later resubmission of the same transaction bytes (e.g. the peer reconnects, or a different
+	// peer relays it) retries from scratch instead of being dropped.
+	Aborted
+)
+
+// invalidMilestoneHashes contains transaction hashes of known invalid milestones which should
+// never be accepted, regardless of where they were received from.
+var invalidMilestoneHashes = map[string]struct{}{}
+
+// workUnitFactory creates a new WorkUnit from the given key as required by the objectstorage.
+func workUnitFactory(key []byte) objectstorage.StorableObject {
+	return newWorkUnit(key)
+}
+
+// newWorkUnit creates a new WorkUnit around the given received transaction bytes.
+func newWorkUnit(receivedTxBytes []byte) *WorkUnit {
+	txHash := sha256.Sum256(receivedTxBytes)
+	return &WorkUnit{
+		receivedMsgBytes: receivedTxBytes,
+		receivedTxHash:   txHash[:],
+		receivedFrom:     make(map[string]*peer.Peer),
+	}
+}
+
+// CachedWorkUnit is a wrapped CachedObject around a WorkUnit.
+type CachedWorkUnit struct {
+	objectstorage.CachedObject
+}
+
+// WorkUnit retrieves the underlying WorkUnit.
+func (c *CachedWorkUnit) WorkUnit() *WorkUnit {
+	return c.Get().(*WorkUnit)
+}
+
+// WorkUnit represents the processing pipeline state of a single received transaction. The same
+// transaction bytes can be received from multiple peers concurrently, in which case they all
+// share (and drive forward) the same WorkUnit.
+type WorkUnit struct {
+	objectstorage.StorableObjectFlags
+
+	// processingLock guards the state field and the decision of whether to (re)start processing.
+	processingLock sync.Mutex
+	state          WorkUnitState
+
+	// dataLock guards the fields populated while processing the WorkUnit.
+	dataLock         sync.RWMutex
+	receivedMsgBytes []byte
+	receivedTxHash   []byte
+	receivedMsgID    hornet.Hash
+	msg              *tangle.Message
+
+	// ctx is cancelled once every peer that ever submitted this WorkUnit has been dropped, so
+	// in-flight hashing/validation can abandon expensive work instead of running to completion
+	// for nobody. It is independent of any single receiver's context: addReceivedFrom starts one
+	// watcher per receiver, and removeReceivedFrom cancels ctx once the last receiver is gone.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// err caches the first error encountered while processing the underlying transaction bytes,
+	// so that a repeated processWorkUnit call for the same bytes doesn't re-hash/re-validate
+	// only to arrive at the same failure.
+	err error
+
+	receivedFromLock sync.Mutex
+	receivedFrom     map[string]*peer.Peer
+}
+
+// Is tells whether the WorkUnit is in the given state.
+func (wu *WorkUnit) Is(state WorkUnitState) bool {
+	return wu.state == state
+}
+
+// UpdateState updates the WorkUnit's state.
+func (wu *WorkUnit) UpdateState(state WorkUnitState) {
+	wu.state = state
+	wu.SetModified()
+}
+
+// SetError caches the first error encountered while processing this WorkUnit's transaction
+// bytes. Subsequent calls are no-ops, so the first failure is always what's returned.
+func (wu *WorkUnit) SetError(err error) {
+	wu.dataLock.Lock()
+	defer wu.dataLock.Unlock()
+	if wu.err == nil {
+		wu.err = err
+	}
+}
+
+// Error returns the first error encountered while processing this WorkUnit's transaction bytes,
+// or nil if none occurred (yet).
+func (wu *WorkUnit) Error() error {
+	wu.dataLock.RLock()
+	defer wu.dataLock.RUnlock()
+	return wu.err
+}
+
+// addReceivedFrom registers that the given peer submitted this WorkUnit's transaction bytes,
+// folding the peer's context into the WorkUnit's own cancellation so the processing of this
+// WorkUnit is only abandoned once every receiver is gone.
+func (wu *WorkUnit) addReceivedFrom(p *peer.Peer, ctx context.Context) {
+	wu.receivedFromLock.Lock()
+	defer wu.receivedFromLock.Unlock()
+
+	_, alreadyReceived := wu.receivedFrom[p.ID]
+	wu.receivedFrom[p.ID] = p
+
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	if wu.ctx == nil {
+		// the WorkUnit's own context is independent of any single receiver's context; it is only
+		// ever cancelled once removeReceivedFrom has removed the last receiver, at which point it
+		// is cleared again so a later resubmission (a different peer relaying the same bytes, or
+		// this peer reconnecting) gets a fresh, live context instead of an already-cancelled one.
+		wu.ctx, wu.cancel = context.WithCancel(context.Background())
+	}
+
+	if alreadyReceived {
+		// this peer already has a watcher goroutine running for this WorkUnit from an earlier
+		// submission (e.g. a duplicate relay/retransmit of the same tx bytes); spawning another
+		// one would leak a goroutine blocked on the same peer context for as long as the WorkUnit
+		// is cached, for no benefit.
+		return
+	}
+
+	// watch this receiver's own context and remove only this receiver once it fires. Each
+	// receiver gets its own watcher so a WorkUnit shared by multiple peers isn't torn down (or
+	// left dangling) by another receiver's context firing.
+	go func(peerID string, peerCtx context.Context) {
+		<-peerCtx.Done()
+		wu.removeReceivedFrom(peerID)
+	}(p.ID, ctx)
+}
+
+// removeReceivedFrom unregisters the given peer from this WorkUnit. Once no receivers are left,
+// the WorkUnit's context is cancelled so any in-flight hashing/validation can abort, and then
+// cleared so the next addReceivedFrom (0->1 receivers) creates a fresh, live context rather than
+// reusing this now-permanently-cancelled one.
+func (wu *WorkUnit) removeReceivedFrom(peerID string) {
+	wu.receivedFromLock.Lock()
+	defer wu.receivedFromLock.Unlock()
+
+	delete(wu.receivedFrom, peerID)
+
+	if len(wu.receivedFrom) == 0 && wu.cancel != nil {
+		wu.cancel()
+		wu.ctx, wu.cancel = nil, nil
+	}
+}
+
+// Context returns the WorkUnit's context, which is cancelled once every peer that submitted it
+// has been removed or the processor is shutting down.
+func (wu *WorkUnit) Context() context.Context {
+	wu.receivedFromLock.Lock()
+	defer wu.receivedFromLock.Unlock()
+	if wu.ctx == nil {
+		// no receiver is left, so there is nobody to process this WorkUnit for
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		return ctx
+	}
+	return wu.ctx
+}
+
+// punish drops the connection to every peer that submitted this WorkUnit's invalid transaction.
+func (wu *WorkUnit) punish() {
+	wu.receivedFromLock.Lock()
+	defer wu.receivedFromLock.Unlock()
+	for _, p := range wu.receivedFrom {
+		p.Disconnect()
+	}
+}
+
+// increaseKnownTxCount increases the known transaction counter on every peer that submitted this
+// WorkUnit's transaction, except for the peer who made us aware of it being known/broadcasted.
+func (wu *WorkUnit) increaseKnownTxCount(except *peer.Peer) {
+	wu.receivedFromLock.Lock()
+	defer wu.receivedFromLock.Unlock()
+	for _, p := range wu.receivedFrom {
+		if p.ID == except.ID {
+			continue
+		}
+		p.Metrics.KnownTransactions.Inc()
+	}
+}
+
+// broadcast builds a bqueue.Broadcast of this WorkUnit's transaction, excluding every peer that
+// already sent it to us.
+func (wu *WorkUnit) broadcast() *bqueue.Broadcast {
+	wu.receivedFromLock.Lock()
+	defer wu.receivedFromLock.Unlock()
+
+	exclude := make(map[string]struct{}, len(wu.receivedFrom))
+	for peerID := range wu.receivedFrom {
+		exclude[peerID] = struct{}{}
+	}
+
+	return &bqueue.Broadcast{
+		MsgData:        wu.receivedMsgBytes,
+		ExcludePeerIDs: exclude,
+	}
+}
+
+// Update is unused, as a WorkUnit is fully replaced by deletion and recreation.
+func (wu *WorkUnit) Update(other objectstorage.StorableObject) {}
+
+// ObjectStorageKey returns the key of the WorkUnit, i.e. the received transaction bytes.
+func (wu *WorkUnit) ObjectStorageKey() []byte {
+	return wu.receivedMsgBytes
+}
+
+// ObjectStorageValue returns nil, as a WorkUnit is kept in-memory only (KeysOnly/PersistenceEnabled(false)).
+func (wu *WorkUnit) ObjectStorageValue() (_ []byte) {
+	return nil
+}
+
+// UnmarshalObjectStorageValue is unused, as a WorkUnit is kept in-memory only.
+func (wu *WorkUnit) UnmarshalObjectStorageValue(_ []byte) (consumedBytes int, err error) {
+	return 0, nil
+}
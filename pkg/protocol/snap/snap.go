@@ -0,0 +1,448 @@
+// Package snap implements the "snap" wire protocol, a companion to "sting" that lets a
+// joining node bootstrap from a recent global ledger snapshot instead of replaying every
+// milestone from genesis.
+package snap
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/gohornet/hornet/pkg/protocol/message"
+)
+
+// ProtocolID identifies the snap protocol on the wire, analogous to sting.ProtocolID.
+const ProtocolID = "snap"
+
+// Message types handled by the snap protocol.
+const (
+	MessageTypeGetSnapshotManifest message.Type = 20 + iota
+	MessageTypeSnapshotManifest
+	MessageTypeGetAddressRange
+	MessageTypeAddressRange
+	MessageTypeGetMilestoneDiffs
+	MessageTypeMilestoneDiffs
+)
+
+// MaxAddressesPerRange caps the number of address-balance entries returned in a single
+// GetAddressRange response, so a lexicographic range walk always fits in one message.
+const MaxAddressesPerRange = 500
+
+// MaxSolidEntryPoints caps the number of solid entry points a SnapshotManifest can carry. It is
+// generous relative to any real snapshot's solid entry point set, existing only so a malformed or
+// malicious sepCount can't force ParseSnapshotManifest to allocate a huge slice before reading any
+// of the actual payload.
+const MaxSolidEntryPoints = 100000
+
+var (
+	// ErrInvalidGetAddressRangeMessage is returned when a GetAddressRange request can't be parsed.
+	ErrInvalidGetAddressRangeMessage = errors.New("invalid GetAddressRange message")
+	// ErrInvalidAddressRangeMessage is returned when an AddressRange response can't be parsed.
+	ErrInvalidAddressRangeMessage = errors.New("invalid AddressRange message")
+	// ErrInvalidGetMilestoneDiffsMessage is returned when a GetMilestoneDiffs request can't be parsed.
+	ErrInvalidGetMilestoneDiffsMessage = errors.New("invalid GetMilestoneDiffs message")
+	// ErrInvalidSnapshotManifestMessage is returned when a SnapshotManifest response can't be parsed.
+	ErrInvalidSnapshotManifestMessage = errors.New("invalid SnapshotManifest message")
+)
+
+// SnapshotManifest describes the snapshot a peer currently has to offer, so that a syncing
+// node can decide whether it is recent enough and verify the chunks it pulls against it.
+type SnapshotManifest struct {
+	// PruningIndex is the milestone index the offering peer has pruned up to.
+	PruningIndex uint32
+	// SolidEntryPoints are the hashes of the solid entry points of the snapshot.
+	SolidEntryPoints [][]byte
+	// LedgerDiffRangeFrom/To is the inclusive milestone range of ledger diffs available for replay.
+	LedgerDiffRangeFrom uint32
+	LedgerDiffRangeTo   uint32
+	// Digest is a digest over the address-balance chunks of the snapshot, used by SnapSyncer to
+	// verify that chunks pulled from (possibly different) peers actually belong to this manifest.
+	Digest [32]byte
+}
+
+// AddressBalance is a single address/balance pair as delivered by GetAddressRange.
+type AddressBalance struct {
+	Address []byte
+	Balance uint64
+}
+
+// FoldAddressChunkDigest folds an address-balance chunk into a running digest over the
+// address-balance pairs of a manifest's full address range. Both the offering side (which computes the
+// digest to put into a SnapshotManifest) and SnapSyncer (which re-derives it while pulling
+// chunks) call this with the same chunk order, so the final value only matches if every chunk
+// was intact and delivered in order.
+func FoldAddressChunkDigest(running [32]byte, addresses []AddressBalance) [32]byte {
+	h := sha256.New()
+	h.Write(running[:])
+	var balance [8]byte
+	for _, ab := range addresses {
+		h.Write(ab.Address)
+		binary.LittleEndian.PutUint64(balance[:], ab.Balance)
+		h.Write(balance[:])
+	}
+	var next [32]byte
+	copy(next[:], h.Sum(nil))
+	return next
+}
+
+// DigestAddressSequence folds a complete, ordered address-balance sequence into the same digest
+// FoldAddressChunkDigest produces when walking it in canonical MaxAddressesPerRange-sized pages
+// from the start. It lets a caller that assembled the sequence through some other chunking (e.g.
+// concurrent, differently-bounded requests) verify it against a digest computed by the canonical
+// walk, without needing to replay requests in that exact order itself.
+func DigestAddressSequence(addresses []AddressBalance) [32]byte {
+	var digest [32]byte
+	for i := 0; i < len(addresses); i += MaxAddressesPerRange {
+		end := i + MaxAddressesPerRange
+		if end > len(addresses) {
+			end = len(addresses)
+		}
+		digest = FoldAddressChunkDigest(digest, addresses[i:end])
+	}
+	return digest
+}
+
+// NextAddress returns the lexicographically smallest byte string strictly greater than addr. A
+// caller paginating a [From, To) GetAddressRangeRequest by re-requesting from the last address of
+// the previous response must pass NextAddress(lastAddress) rather than lastAddress itself, or the
+// From-inclusive bound re-requests (and re-includes) that same address in the next page.
+func NextAddress(addr []byte) []byte {
+	next := make([]byte, len(addr)+1)
+	copy(next, addr)
+	return next
+}
+
+// GetAddressRangeRequest asks for the balances of all addresses in [From, To) lexicographically,
+// capped at MaxAddressesPerRange entries per response.
+type GetAddressRangeRequest struct {
+	From []byte
+	To   []byte
+}
+
+// AddressRangeResponse is the reply to a GetAddressRangeRequest.
+type AddressRangeResponse struct {
+	Addresses []AddressBalance
+	// Exhausted is true if there are no more addresses after this chunk within the requested range.
+	Exhausted bool
+}
+
+// GetMilestoneDiffsRequest asks for the ledger diffs of the inclusive milestone range [From, To].
+type GetMilestoneDiffsRequest struct {
+	From uint32
+	To   uint32
+}
+
+// MilestoneDiffResponse streams a single milestone's ledger diff. GetMilestoneDiffs is answered
+// with one MilestoneDiffResponse per milestone in the requested range, followed by a final one
+// with MilestoneIndex == MilestoneDiffsTerminator marking the end of the stream - the requested
+// range may be answered with fewer diffs than asked for (the offering side stops once it runs out
+// of range to serve), so the terminator is what tells a requester the stream is done rather than
+// merely delayed.
+type MilestoneDiffResponse struct {
+	MilestoneIndex uint32
+	Diff           []byte
+}
+
+// MilestoneDiffsTerminator is the MilestoneIndex value marking the final MilestoneDiffResponse of
+// a GetMilestoneDiffs reply stream. Milestone index 0 is never a valid milestone, so it is safe
+// to use as a sentinel.
+const MilestoneDiffsTerminator uint32 = 0
+
+// NewGetSnapshotManifestMessage creates a new GetSnapshotManifest message. The request carries no payload.
+func NewGetSnapshotManifestMessage() (message.Message, error) {
+	return message.NewMessage(MessageTypeGetSnapshotManifest, nil)
+}
+
+// NewSnapshotManifestMessage creates a new SnapshotManifest message from the given manifest.
+func NewSnapshotManifestMessage(manifest *SnapshotManifest) (message.Message, error) {
+	data, err := encodeSnapshotManifest(manifest)
+	if err != nil {
+		return nil, err
+	}
+	return message.NewMessage(MessageTypeSnapshotManifest, data)
+}
+
+// encodeSnapshotManifest builds the wire payload of a SnapshotManifest message, factored out of
+// NewSnapshotManifestMessage so it can be exercised directly (without going through the opaque
+// message.Message envelope) by ParseSnapshotManifest's round-trip tests.
+func encodeSnapshotManifest(manifest *SnapshotManifest) ([]byte, error) {
+	var buf bytes.Buffer
+
+	if err := binary.Write(&buf, binary.LittleEndian, manifest.PruningIndex); err != nil {
+		return nil, err
+	}
+
+	if err := binary.Write(&buf, binary.LittleEndian, uint32(len(manifest.SolidEntryPoints))); err != nil {
+		return nil, err
+	}
+	for _, sep := range manifest.SolidEntryPoints {
+		if err := binary.Write(&buf, binary.LittleEndian, uint16(len(sep))); err != nil {
+			return nil, err
+		}
+		buf.Write(sep)
+	}
+
+	if err := binary.Write(&buf, binary.LittleEndian, manifest.LedgerDiffRangeFrom); err != nil {
+		return nil, err
+	}
+	if err := binary.Write(&buf, binary.LittleEndian, manifest.LedgerDiffRangeTo); err != nil {
+		return nil, err
+	}
+	buf.Write(manifest.Digest[:])
+
+	return buf.Bytes(), nil
+}
+
+// ParseSnapshotManifest parses the payload of a SnapshotManifest message.
+func ParseSnapshotManifest(data []byte) (*SnapshotManifest, error) {
+	r := bytes.NewReader(data)
+
+	manifest := &SnapshotManifest{}
+	if err := binary.Read(r, binary.LittleEndian, &manifest.PruningIndex); err != nil {
+		return nil, ErrInvalidSnapshotManifestMessage
+	}
+
+	var sepCount uint32
+	if err := binary.Read(r, binary.LittleEndian, &sepCount); err != nil {
+		return nil, ErrInvalidSnapshotManifestMessage
+	}
+	if sepCount > MaxSolidEntryPoints {
+		return nil, ErrInvalidSnapshotManifestMessage
+	}
+	manifest.SolidEntryPoints = make([][]byte, sepCount)
+	for i := range manifest.SolidEntryPoints {
+		var sepLen uint16
+		if err := binary.Read(r, binary.LittleEndian, &sepLen); err != nil {
+			return nil, ErrInvalidSnapshotManifestMessage
+		}
+		sep := make([]byte, sepLen)
+		if _, err := io.ReadFull(r, sep); err != nil {
+			return nil, ErrInvalidSnapshotManifestMessage
+		}
+		manifest.SolidEntryPoints[i] = sep
+	}
+
+	if err := binary.Read(r, binary.LittleEndian, &manifest.LedgerDiffRangeFrom); err != nil {
+		return nil, ErrInvalidSnapshotManifestMessage
+	}
+	if err := binary.Read(r, binary.LittleEndian, &manifest.LedgerDiffRangeTo); err != nil {
+		return nil, ErrInvalidSnapshotManifestMessage
+	}
+	if _, err := io.ReadFull(r, manifest.Digest[:]); err != nil {
+		return nil, ErrInvalidSnapshotManifestMessage
+	}
+
+	if manifest.LedgerDiffRangeTo < manifest.LedgerDiffRangeFrom {
+		return nil, ErrInvalidSnapshotManifestMessage
+	}
+
+	return manifest, nil
+}
+
+// NewGetAddressRangeMessage creates a new GetAddressRange message.
+func NewGetAddressRangeMessage(req *GetAddressRangeRequest) (message.Message, error) {
+	data, err := encodeGetAddressRangeRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	return message.NewMessage(MessageTypeGetAddressRange, data)
+}
+
+// encodeGetAddressRangeRequest builds the wire payload of a GetAddressRange message, factored out
+// of NewGetAddressRangeMessage so ParseGetAddressRangeRequest's round-trip tests can exercise it
+// directly.
+func encodeGetAddressRangeRequest(req *GetAddressRangeRequest) ([]byte, error) {
+	var buf bytes.Buffer
+
+	if err := binary.Write(&buf, binary.LittleEndian, uint16(len(req.From))); err != nil {
+		return nil, err
+	}
+	buf.Write(req.From)
+
+	if err := binary.Write(&buf, binary.LittleEndian, uint16(len(req.To))); err != nil {
+		return nil, err
+	}
+	buf.Write(req.To)
+
+	return buf.Bytes(), nil
+}
+
+// ParseGetAddressRangeRequest parses the payload of a GetAddressRange message.
+func ParseGetAddressRangeRequest(data []byte) (*GetAddressRangeRequest, error) {
+	r := bytes.NewReader(data)
+
+	req := &GetAddressRangeRequest{}
+	var fromLen, toLen uint16
+	if err := binary.Read(r, binary.LittleEndian, &fromLen); err != nil {
+		return nil, ErrInvalidGetAddressRangeMessage
+	}
+	req.From = make([]byte, fromLen)
+	if _, err := io.ReadFull(r, req.From); err != nil {
+		return nil, ErrInvalidGetAddressRangeMessage
+	}
+
+	if err := binary.Read(r, binary.LittleEndian, &toLen); err != nil {
+		return nil, ErrInvalidGetAddressRangeMessage
+	}
+	req.To = make([]byte, toLen)
+	if _, err := io.ReadFull(r, req.To); err != nil {
+		return nil, ErrInvalidGetAddressRangeMessage
+	}
+
+	return req, nil
+}
+
+// NewAddressRangeMessage creates a new AddressRange message from the given response. A response
+// with more than MaxAddressesPerRange entries is rejected with ErrInvalidAddressRangeMessage.
+func NewAddressRangeMessage(resp *AddressRangeResponse) (message.Message, error) {
+	data, err := encodeAddressRangeResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+	return message.NewMessage(MessageTypeAddressRange, data)
+}
+
+// encodeAddressRangeResponse builds the wire payload of an AddressRange message, factored out of
+// NewAddressRangeMessage so ParseAddressRangeResponse's round-trip tests can exercise it directly.
+func encodeAddressRangeResponse(resp *AddressRangeResponse) ([]byte, error) {
+	if len(resp.Addresses) > MaxAddressesPerRange {
+		return nil, ErrInvalidAddressRangeMessage
+	}
+
+	var buf bytes.Buffer
+
+	if err := binary.Write(&buf, binary.LittleEndian, uint32(len(resp.Addresses))); err != nil {
+		return nil, err
+	}
+	for _, ab := range resp.Addresses {
+		if err := binary.Write(&buf, binary.LittleEndian, uint16(len(ab.Address))); err != nil {
+			return nil, err
+		}
+		buf.Write(ab.Address)
+		if err := binary.Write(&buf, binary.LittleEndian, ab.Balance); err != nil {
+			return nil, err
+		}
+	}
+
+	exhausted := byte(0)
+	if resp.Exhausted {
+		exhausted = 1
+	}
+	buf.WriteByte(exhausted)
+
+	return buf.Bytes(), nil
+}
+
+// ParseAddressRangeResponse parses the payload of an AddressRange message.
+func ParseAddressRangeResponse(data []byte) (*AddressRangeResponse, error) {
+	r := bytes.NewReader(data)
+
+	var count uint32
+	if err := binary.Read(r, binary.LittleEndian, &count); err != nil {
+		return nil, ErrInvalidAddressRangeMessage
+	}
+	if count > MaxAddressesPerRange {
+		return nil, ErrInvalidAddressRangeMessage
+	}
+
+	resp := &AddressRangeResponse{Addresses: make([]AddressBalance, count)}
+	for i := range resp.Addresses {
+		var addrLen uint16
+		if err := binary.Read(r, binary.LittleEndian, &addrLen); err != nil {
+			return nil, ErrInvalidAddressRangeMessage
+		}
+		addr := make([]byte, addrLen)
+		if _, err := io.ReadFull(r, addr); err != nil {
+			return nil, ErrInvalidAddressRangeMessage
+		}
+		var balance uint64
+		if err := binary.Read(r, binary.LittleEndian, &balance); err != nil {
+			return nil, ErrInvalidAddressRangeMessage
+		}
+		resp.Addresses[i] = AddressBalance{Address: addr, Balance: balance}
+	}
+
+	exhausted, err := r.ReadByte()
+	if err != nil {
+		return nil, ErrInvalidAddressRangeMessage
+	}
+	resp.Exhausted = exhausted == 1
+
+	return resp, nil
+}
+
+// NewGetMilestoneDiffsMessage creates a new GetMilestoneDiffs message.
+func NewGetMilestoneDiffsMessage(req *GetMilestoneDiffsRequest) (message.Message, error) {
+	data, err := encodeGetMilestoneDiffsRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	return message.NewMessage(MessageTypeGetMilestoneDiffs, data)
+}
+
+// encodeGetMilestoneDiffsRequest builds the wire payload of a GetMilestoneDiffs message, factored
+// out of NewGetMilestoneDiffsMessage so ParseGetMilestoneDiffsRequest's round-trip tests can
+// exercise it directly.
+func encodeGetMilestoneDiffsRequest(req *GetMilestoneDiffsRequest) ([]byte, error) {
+	var buf bytes.Buffer
+
+	if err := binary.Write(&buf, binary.LittleEndian, req.From); err != nil {
+		return nil, err
+	}
+	if err := binary.Write(&buf, binary.LittleEndian, req.To); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// ParseGetMilestoneDiffsRequest parses the payload of a GetMilestoneDiffs message.
+func ParseGetMilestoneDiffsRequest(data []byte) (*GetMilestoneDiffsRequest, error) {
+	if len(data) != 8 {
+		return nil, ErrInvalidGetMilestoneDiffsMessage
+	}
+
+	req := &GetMilestoneDiffsRequest{
+		From: binary.LittleEndian.Uint32(data[:4]),
+		To:   binary.LittleEndian.Uint32(data[4:8]),
+	}
+	return req, nil
+}
+
+// NewMilestoneDiffMessage creates a new MilestoneDiffs message carrying a single milestone's diff.
+// GetMilestoneDiffs is answered with one of these per milestone in the requested range.
+func NewMilestoneDiffMessage(resp *MilestoneDiffResponse) (message.Message, error) {
+	data, err := encodeMilestoneDiffResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+	return message.NewMessage(MessageTypeMilestoneDiffs, data)
+}
+
+// encodeMilestoneDiffResponse builds the wire payload of a MilestoneDiffs message, factored out
+// of NewMilestoneDiffMessage so ParseMilestoneDiffResponse's round-trip tests can exercise it
+// directly.
+func encodeMilestoneDiffResponse(resp *MilestoneDiffResponse) ([]byte, error) {
+	var buf bytes.Buffer
+
+	if err := binary.Write(&buf, binary.LittleEndian, resp.MilestoneIndex); err != nil {
+		return nil, err
+	}
+	buf.Write(resp.Diff)
+
+	return buf.Bytes(), nil
+}
+
+// ParseMilestoneDiffResponse parses the payload of a MilestoneDiffs message.
+func ParseMilestoneDiffResponse(data []byte) (*MilestoneDiffResponse, error) {
+	if len(data) < 4 {
+		return nil, errors.New("invalid MilestoneDiffs message")
+	}
+
+	return &MilestoneDiffResponse{
+		MilestoneIndex: binary.LittleEndian.Uint32(data[:4]),
+		Diff:           data[4:],
+	}, nil
+}
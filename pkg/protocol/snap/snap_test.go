@@ -0,0 +1,234 @@
+package snap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+func TestSnapshotManifestRoundTrip(t *testing.T) {
+	want := &SnapshotManifest{
+		PruningIndex:        42,
+		SolidEntryPoints:    [][]byte{{1, 2, 3}, {4, 5}},
+		LedgerDiffRangeFrom: 43,
+		LedgerDiffRangeTo:   100,
+		Digest:              [32]byte{0xaa, 0xbb},
+	}
+
+	data, err := encodeSnapshotManifest(want)
+	if err != nil {
+		t.Fatalf("encodeSnapshotManifest() error = %v", err)
+	}
+
+	got, err := ParseSnapshotManifest(data)
+	if err != nil {
+		t.Fatalf("ParseSnapshotManifest() error = %v", err)
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Fatalf("round trip mismatch: want %+v, got %+v", want, got)
+	}
+}
+
+func TestParseSnapshotManifestRejectsInvertedLedgerDiffRange(t *testing.T) {
+	data, err := encodeSnapshotManifest(&SnapshotManifest{
+		LedgerDiffRangeFrom: 100,
+		LedgerDiffRangeTo:   1,
+	})
+	if err != nil {
+		t.Fatalf("encodeSnapshotManifest() error = %v", err)
+	}
+
+	if _, err := ParseSnapshotManifest(data); err != ErrInvalidSnapshotManifestMessage {
+		t.Fatalf("ParseSnapshotManifest() error = %v, want ErrInvalidSnapshotManifestMessage", err)
+	}
+}
+
+func TestParseSnapshotManifestRejectsOversizedSepCount(t *testing.T) {
+	var buf bytes.Buffer
+	_ = binary.Write(&buf, binary.LittleEndian, uint32(0))          // PruningIndex
+	_ = binary.Write(&buf, binary.LittleEndian, uint32(0xFFFFFFFF)) // sepCount: malicious, would allocate ~100GB of slice headers if unchecked
+	_ = binary.Write(&buf, binary.LittleEndian, uint32(1))          // LedgerDiffRangeFrom
+	_ = binary.Write(&buf, binary.LittleEndian, uint32(1))          // LedgerDiffRangeTo
+	buf.Write(make([]byte, 32))                                     // Digest
+
+	if _, err := ParseSnapshotManifest(buf.Bytes()); err != ErrInvalidSnapshotManifestMessage {
+		t.Fatalf("ParseSnapshotManifest() error = %v, want ErrInvalidSnapshotManifestMessage", err)
+	}
+}
+
+func TestGetAddressRangeRequestRoundTrip(t *testing.T) {
+	want := &GetAddressRangeRequest{From: []byte{1, 2, 3}, To: []byte{9, 9}}
+
+	data, err := encodeGetAddressRangeRequest(want)
+	if err != nil {
+		t.Fatalf("encodeGetAddressRangeRequest() error = %v", err)
+	}
+
+	got, err := ParseGetAddressRangeRequest(data)
+	if err != nil {
+		t.Fatalf("ParseGetAddressRangeRequest() error = %v", err)
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Fatalf("round trip mismatch: want %+v, got %+v", want, got)
+	}
+}
+
+func TestAddressRangeResponseRoundTrip(t *testing.T) {
+	want := &AddressRangeResponse{
+		Addresses: []AddressBalance{
+			{Address: []byte{1, 2, 3}, Balance: 100},
+			{Address: []byte{4, 5, 6}, Balance: 200},
+		},
+		Exhausted: true,
+	}
+
+	data, err := encodeAddressRangeResponse(want)
+	if err != nil {
+		t.Fatalf("encodeAddressRangeResponse() error = %v", err)
+	}
+
+	got, err := ParseAddressRangeResponse(data)
+	if err != nil {
+		t.Fatalf("ParseAddressRangeResponse() error = %v", err)
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Fatalf("round trip mismatch: want %+v, got %+v", want, got)
+	}
+}
+
+func TestParseAddressRangeResponseRejectsOversizedCount(t *testing.T) {
+	var buf bytes.Buffer
+	_ = binary.Write(&buf, binary.LittleEndian, uint32(0xFFFFFFFF)) // count: malicious, would allocate a huge slice if unchecked
+
+	if _, err := ParseAddressRangeResponse(buf.Bytes()); err != ErrInvalidAddressRangeMessage {
+		t.Fatalf("ParseAddressRangeResponse() error = %v, want ErrInvalidAddressRangeMessage", err)
+	}
+}
+
+func TestNewAddressRangeMessageRejectsOverCap(t *testing.T) {
+	addresses := make([]AddressBalance, MaxAddressesPerRange+1)
+	if _, err := NewAddressRangeMessage(&AddressRangeResponse{Addresses: addresses}); err != ErrInvalidAddressRangeMessage {
+		t.Fatalf("NewAddressRangeMessage() error = %v, want ErrInvalidAddressRangeMessage", err)
+	}
+}
+
+func TestGetMilestoneDiffsRequestRoundTrip(t *testing.T) {
+	want := &GetMilestoneDiffsRequest{From: 5, To: 10}
+
+	data, err := encodeGetMilestoneDiffsRequest(want)
+	if err != nil {
+		t.Fatalf("encodeGetMilestoneDiffsRequest() error = %v", err)
+	}
+
+	got, err := ParseGetMilestoneDiffsRequest(data)
+	if err != nil {
+		t.Fatalf("ParseGetMilestoneDiffsRequest() error = %v", err)
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Fatalf("round trip mismatch: want %+v, got %+v", want, got)
+	}
+}
+
+func TestMilestoneDiffResponseRoundTrip(t *testing.T) {
+	want := &MilestoneDiffResponse{MilestoneIndex: 7, Diff: []byte{1, 2, 3, 4}}
+
+	data, err := encodeMilestoneDiffResponse(want)
+	if err != nil {
+		t.Fatalf("encodeMilestoneDiffResponse() error = %v", err)
+	}
+
+	got, err := ParseMilestoneDiffResponse(data)
+	if err != nil {
+		t.Fatalf("ParseMilestoneDiffResponse() error = %v", err)
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Fatalf("round trip mismatch: want %+v, got %+v", want, got)
+	}
+}
+
+func TestMilestoneDiffResponseTerminatorRoundTrip(t *testing.T) {
+	data, err := encodeMilestoneDiffResponse(&MilestoneDiffResponse{MilestoneIndex: MilestoneDiffsTerminator})
+	if err != nil {
+		t.Fatalf("encodeMilestoneDiffResponse() error = %v", err)
+	}
+
+	got, err := ParseMilestoneDiffResponse(data)
+	if err != nil {
+		t.Fatalf("ParseMilestoneDiffResponse() error = %v", err)
+	}
+	if got.MilestoneIndex != MilestoneDiffsTerminator {
+		t.Fatalf("MilestoneIndex = %d, want MilestoneDiffsTerminator", got.MilestoneIndex)
+	}
+}
+
+func TestNextAddress(t *testing.T) {
+	tests := []struct {
+		name string
+		addr []byte
+	}{
+		{"empty", nil},
+		{"single byte", []byte{0x42}},
+		{"multi byte", []byte{0x01, 0x02, 0x03}},
+		{"max byte", []byte{0xFF}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := NextAddress(tt.addr)
+			if bytes.Compare(next, tt.addr) <= 0 {
+				t.Fatalf("NextAddress(%v) = %v, want strictly greater than input", tt.addr, next)
+			}
+			// NextAddress must be the smallest such value: nothing should sort between addr and
+			// addr with a single trailing zero byte appended.
+			want := append(append([]byte{}, tt.addr...), 0x00)
+			if !bytes.Equal(next, want) {
+				t.Fatalf("NextAddress(%v) = %v, want %v", tt.addr, next, want)
+			}
+		})
+	}
+}
+
+func TestDigestAddressSequenceMatchesManualPaging(t *testing.T) {
+	var addresses []AddressBalance
+	for i := 0; i < MaxAddressesPerRange*2+7; i++ {
+		addresses = append(addresses, AddressBalance{Address: []byte{byte(i), byte(i >> 8)}, Balance: uint64(i)})
+	}
+
+	// fold manually in the same canonical MaxAddressesPerRange-sized pages DigestAddressSequence
+	// documents itself as replaying.
+	var want [32]byte
+	for i := 0; i < len(addresses); i += MaxAddressesPerRange {
+		end := i + MaxAddressesPerRange
+		if end > len(addresses) {
+			end = len(addresses)
+		}
+		want = FoldAddressChunkDigest(want, addresses[i:end])
+	}
+
+	if got := DigestAddressSequence(addresses); got != want {
+		t.Fatalf("DigestAddressSequence() = %x, want %x", got, want)
+	}
+}
+
+func TestDigestAddressSequenceSensitiveToOrderAndChunking(t *testing.T) {
+	a := AddressBalance{Address: []byte{1}, Balance: 1}
+	b := AddressBalance{Address: []byte{2}, Balance: 2}
+
+	// same addresses, different order -> different digest.
+	d1 := DigestAddressSequence([]AddressBalance{a, b})
+	d2 := DigestAddressSequence([]AddressBalance{b, a})
+	if d1 == d2 {
+		t.Fatalf("DigestAddressSequence() did not distinguish address order")
+	}
+
+	// folding the same two addresses in two separate chunks must not match folding them as one
+	// chunk - FoldAddressChunkDigest hashes the running digest into every chunk, so chunk
+	// boundaries are part of the result (which is why DigestAddressSequence replays the canonical
+	// paging).
+	chunked := FoldAddressChunkDigest(FoldAddressChunkDigest([32]byte{}, []AddressBalance{a}), []AddressBalance{b})
+	unchunked := FoldAddressChunkDigest([32]byte{}, []AddressBalance{a, b})
+	if chunked == unchunked {
+		t.Fatalf("FoldAddressChunkDigest() did not distinguish chunk boundaries")
+	}
+}
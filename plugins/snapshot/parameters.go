@@ -0,0 +1,43 @@
+package snapshot
+
+import (
+	flag "github.com/spf13/pflag"
+
+	"github.com/gohornet/hornet/packages/model/milestone_index"
+	"github.com/gohornet/hornet/packages/parameter"
+)
+
+const (
+	// CfgPruningMode is the config parameter selecting pruningConfig's mode, analogous to
+	// --gcmode archive|full plus the snap mode this plugin adds.
+	CfgPruningMode = "pruning.mode"
+	// CfgPruningTxLookupLimit is the config parameter for pruningConfig's tx-lookup retention
+	// window, analogous to --txlookuplimit.
+	CfgPruningTxLookupLimit = "pruning.txLookupLimit"
+)
+
+func init() {
+	flag.String(CfgPruningMode, string(PruningModeFull), "pruning mode: archive, full or snap")
+	flag.Int(CfgPruningTxLookupLimit, 0, "number of milestones below the solid milestone for which "+
+		"the address lookup index is kept in snap mode; 0 keeps it indefinitely")
+}
+
+// loadPruningConfig builds a PruningConfig from the current CfgPruningMode/CfgPruningTxLookupLimit
+// values and registers a viper config-change callback that hot-reloads both onto it, so an
+// operator can flip pruning.mode or pruning.txLookupLimit in the config file without restarting
+// the node. Must only be called after parameter.NodeConfig has parsed the CLI flags/config file
+// (i.e. from Configure, not from a package-level var initializer), or it would only ever see the
+// init()-registered flag defaults above.
+func loadPruningConfig() *PruningConfig {
+	cfg := NewPruningConfig(
+		PruningMode(parameter.NodeConfig.GetString(CfgPruningMode)),
+		milestone_index.MilestoneIndex(parameter.NodeConfig.GetInt(CfgPruningTxLookupLimit)),
+	)
+
+	parameter.NodeConfig.OnConfigChange(func() {
+		cfg.SetMode(PruningMode(parameter.NodeConfig.GetString(CfgPruningMode)))
+		cfg.SetTxLookupLimit(milestone_index.MilestoneIndex(parameter.NodeConfig.GetInt(CfgPruningTxLookupLimit)))
+	})
+
+	return cfg
+}
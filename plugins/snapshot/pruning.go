@@ -13,11 +13,21 @@ const (
 	// AdditionalPruningThreshold is needed, because the transactions in the getMilestoneApprovees call in getSolidEntryPoints
 	// can reference older transactions as well
 	AdditionalPruningThreshold = 50
+
+	// approxTrytesTransactionSize is a rough estimate of the on-disk size of a single pruned
+	// transaction, used to report PruningMetrics.BytesReclaimed without having to track exact
+	// byte counts through every delete call.
+	approxTrytesTransactionSize = 1604
 )
 
 // pruneUnconfirmedTransactions prunes all unconfirmed tx from the database for the given milestone
 func pruneUnconfirmedTransactions(targetIndex milestone_index.MilestoneIndex) int {
 
+	if pruningConfig.IsPaused() {
+		// an operator suspended pruning, e.g. to run a snapshot export undisturbed
+		return 0
+	}
+
 	txHashes, err := tangle.ReadFirstSeenTxHashOperations(targetIndex)
 	if err != nil {
 		log.Panicf("pruneUnconfirmedTransactions: %v", err.Error())
@@ -75,6 +85,10 @@ func pruneMilestone(milestoneIndex milestone_index.MilestoneIndex) {
 // if the given txHashes are removed from their corresponding bundles
 func pruneTransactions(txHashes []trinary.Hash) int {
 
+	if pruningConfig.IsPaused() {
+		return 0
+	}
+
 	txsToRemove := make(map[trinary.Hash]struct{})
 	var addresses []*tangle.TxHashForAddress
 
@@ -110,17 +124,105 @@ func pruneTransactions(txHashes []trinary.Hash) int {
 		log.Error(err)
 	}
 
+	pruningConfig.addAddressesPurged(uint64(len(addresses)))
+	pruningConfig.addBytesReclaimed(uint64(len(txsToRemove)) * approxTrytesTransactionSize)
+
 	return len(txsToRemove)
 }
 
+// pruneTxLookupForMilestone drops the address->tx-hash reverse lookup index for the confirmed
+// transactions of the given milestone, without touching the bundle/tx data itself. This is the
+// extra step PruningModeSnap takes ahead of the regular bundle/tx pruning at pruningDelay.
+func pruneTxLookupForMilestone(milestoneIndex milestone_index.MilestoneIndex, txHashes []trinary.Hash) int {
+
+	var addresses []*tangle.TxHashForAddress
+
+	for _, txHash := range txHashes {
+		cachedTx := tangle.GetCachedTransaction(txHash) // tx +1
+		if !cachedTx.Exists() {
+			cachedTx.Release() // tx -1
+			continue
+		}
+
+		addresses = append(addresses, &tangle.TxHashForAddress{TxHash: txHash, Address: cachedTx.GetTransaction().Tx.Address})
+		cachedTx.Release() // tx -1
+	}
+
+	if err := tangle.DeleteTransactionHashesForAddressesInDatabase(addresses); err != nil {
+		log.Error(err)
+	}
+
+	pruningConfig.addAddressesPurged(uint64(len(addresses)))
+
+	return len(addresses)
+}
+
+// pruneTxLookupIndex runs the PruningModeSnap-only step of pruneDatabase: it walks forward from
+// the last milestone whose lookup index was pruned (pruningConfig.TxLookupPruningIndex) up to
+// solidMilestoneIndex-TxLookupLimit, dropping the address->tx-hash lookup index for each one.
+func pruneTxLookupIndex(solidMilestoneIndex milestone_index.MilestoneIndex, abortSignal <-chan struct{}) {
+
+	txLookupLimit := pruningConfig.TxLookupLimit()
+	if txLookupLimit == 0 || solidMilestoneIndex <= txLookupLimit {
+		return
+	}
+
+	targetIndex := solidMilestoneIndex - txLookupLimit
+	lastPruned := pruningConfig.TxLookupPruningIndex()
+	if lastPruned >= targetIndex {
+		return
+	}
+
+	for milestoneIndex := lastPruned + 1; milestoneIndex <= targetIndex; milestoneIndex++ {
+		select {
+		case <-abortSignal:
+			return
+		default:
+		}
+
+		if pruningConfig.IsPaused() {
+			return
+		}
+
+		cachedMs := tangle.GetMilestoneOrNil(milestoneIndex) // bundle +1
+		if cachedMs == nil {
+			continue
+		}
+
+		cachedMsTailTx := cachedMs.GetBundle().GetTail() // tx +1
+		cachedMs.Release()                               // bundle -1
+
+		approvees, err := getMilestoneApprovees(milestoneIndex, cachedMsTailTx.Retain(), false, nil)
+		cachedMsTailTx.Release() // tx -1
+		if err != nil {
+			log.Errorf("Pruning tx lookup index for milestone (%d) failed! %v", milestoneIndex, err)
+			continue
+		}
+
+		purged := pruneTxLookupForMilestone(milestoneIndex, approvees)
+		log.Infof("Pruned tx lookup index for milestone (%d): %d addresses", milestoneIndex, purged)
+	}
+
+	pruningConfig.SetTxLookupPruningIndex(targetIndex)
+}
+
 // ToDo: Global pruning Lock needed?
 func pruneDatabase(solidMilestoneIndex milestone_index.MilestoneIndex, abortSignal <-chan struct{}) {
 
+	if pruningConfig.Mode() == PruningModeArchive {
+		// archive nodes keep everything; there is nothing to prune
+		return
+	}
+
 	snapshotInfo := tangle.GetSnapshotInfo()
 	if snapshotInfo == nil {
 		log.Panic("No snapshotInfo found!")
 	}
 
+	if pruningConfig.Mode() == PruningModeSnap {
+		pruneTxLookupIndex(solidMilestoneIndex, abortSignal)
+	}
+
 	targetIndex := solidMilestoneIndex - pruningDelay
 	targetIndexMax := (snapshotInfo.SnapshotIndex - SolidEntryPointCheckThresholdPast - AdditionalPruningThreshold - 1)
 	if targetIndex > targetIndexMax {
@@ -141,6 +243,11 @@ func pruneDatabase(solidMilestoneIndex milestone_index.MilestoneIndex, abortSign
 		default:
 		}
 
+		if pruningConfig.IsPaused() {
+			log.Info("Pruning paused, resuming from this milestone once unpaused")
+			return
+		}
+
 		log.Infof("Pruning milestone (%d)...", milestoneIndex)
 
 		ts := time.Now()
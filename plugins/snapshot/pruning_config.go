@@ -0,0 +1,150 @@
+package snapshot
+
+import (
+	"sync/atomic"
+
+	"github.com/gohornet/hornet/packages/model/milestone_index"
+)
+
+// PruningMode controls how aggressively pruneDatabase trims the local database, mirroring the
+// tradeoff surface of --gcmode archive|full plus --txlookuplimit: keep everything, keep everything
+// needed to fully verify the ledger, or additionally drop the address lookup index beyond a window.
+type PruningMode string
+
+const (
+	// PruningModeArchive keeps every milestone, bundle, transaction and lookup index forever;
+	// pruneDatabase is a no-op in this mode.
+	PruningModeArchive PruningMode = "archive"
+	// PruningModeFull prunes unconfirmed and confirmed-but-pruned-range transactions/milestones
+	// as pruneDatabase always has, keeping the address lookup index intact.
+	PruningModeFull PruningMode = "full"
+	// PruningModeSnap behaves like PruningModeFull, and additionally drops the address->tx-hash
+	// reverse lookup index for milestones older than TxLookupLimit below the solid milestone.
+	PruningModeSnap PruningMode = "snap"
+)
+
+// PruningConfig drives pruneDatabase's behavior and can be swapped out at runtime (e.g. in
+// response to a config hot-reload), so an operator doesn't need to restart the node to change
+// the pruning policy or to pause pruning during a snapshot export.
+type PruningConfig struct {
+	// mode is read/written atomically so pruneDatabase always observes a consistent mode even
+	// while a reload is in progress.
+	mode atomic.Value // PruningMode
+
+	// txLookupLimit is the number of milestones below the solid milestone for which the
+	// address->tx-hash reverse index is kept in PruningModeSnap. Atomic for the same reason as mode.
+	txLookupLimit int64
+
+	// txLookupPruningIndex is the watermark up to (and including) which the address->tx-hash
+	// reverse index has already been dropped in PruningModeSnap. It lives here rather than on
+	// tangle.SnapshotInfo because it is a property of the pruning policy, not of the ledger state
+	// a snapshot captures. It is kept in memory only and is not loaded from any config parameter,
+	// so it starts at 0 on every node start and the first pruneTxLookupIndex pass after a restart
+	// walks forward from milestone 1 again.
+	txLookupPruningIndex int64
+
+	// paused suspends pruneUnconfirmedTransactions/pruneTransactions when non-zero, so an
+	// operator can freeze pruning during a snapshot export without restarting the node.
+	paused int32
+
+	metrics PruningMetrics
+}
+
+// PruningMetrics holds cumulative counters so operators can pick a pruning policy based on
+// observed cost rather than guesswork. There is a single set of counters for all modes; they are
+// not broken down by pruning mode and are not reset when the mode changes.
+type PruningMetrics struct {
+	// BytesReclaimed is the cumulative estimate of bytes freed by pruneTransactions, counted as
+	// approxTrytesTransactionSize per pruned transaction. pruneMilestone's deletions are not counted.
+	BytesReclaimed uint64
+	// AddressesPurged is the cumulative number of address->tx-hash lookup entries dropped, by
+	// pruneTransactions in every pruning mode and by pruneTxLookupForMilestone in PruningModeSnap.
+	// An entry dropped early in PruningModeSnap is counted again once pruneTransactions prunes
+	// its transaction.
+	AddressesPurged uint64
+}
+
+// NewPruningConfig creates a PruningConfig in the given mode with the given tx-lookup window.
+func NewPruningConfig(mode PruningMode, txLookupLimit milestone_index.MilestoneIndex) *PruningConfig {
+	cfg := &PruningConfig{}
+	cfg.mode.Store(mode)
+	atomic.StoreInt64(&cfg.txLookupLimit, int64(txLookupLimit))
+	return cfg
+}
+
+// Mode returns the currently configured pruning mode.
+func (cfg *PruningConfig) Mode() PruningMode {
+	return cfg.mode.Load().(PruningMode)
+}
+
+// SetMode hot-reloads the pruning mode.
+func (cfg *PruningConfig) SetMode(mode PruningMode) {
+	cfg.mode.Store(mode)
+}
+
+// TxLookupLimit returns the currently configured tx-lookup retention window.
+func (cfg *PruningConfig) TxLookupLimit() milestone_index.MilestoneIndex {
+	return milestone_index.MilestoneIndex(atomic.LoadInt64(&cfg.txLookupLimit))
+}
+
+// SetTxLookupLimit hot-reloads the tx-lookup retention window.
+func (cfg *PruningConfig) SetTxLookupLimit(limit milestone_index.MilestoneIndex) {
+	atomic.StoreInt64(&cfg.txLookupLimit, int64(limit))
+}
+
+// TxLookupPruningIndex returns the milestone index up to (and including) which the address->tx-hash
+// reverse lookup index has already been pruned away in PruningModeSnap.
+func (cfg *PruningConfig) TxLookupPruningIndex() milestone_index.MilestoneIndex {
+	return milestone_index.MilestoneIndex(atomic.LoadInt64(&cfg.txLookupPruningIndex))
+}
+
+// SetTxLookupPruningIndex advances the tx-lookup pruning watermark to the given milestone index.
+func (cfg *PruningConfig) SetTxLookupPruningIndex(index milestone_index.MilestoneIndex) {
+	atomic.StoreInt64(&cfg.txLookupPruningIndex, int64(index))
+}
+
+// Pause suspends pruneUnconfirmedTransactions/pruneTransactions until Resume is called, so an
+// operator can run a snapshot export without pruning concurrently rewriting the same data.
+func (cfg *PruningConfig) Pause() {
+	atomic.StoreInt32(&cfg.paused, 1)
+}
+
+// Resume lifts a pause previously applied via Pause.
+func (cfg *PruningConfig) Resume() {
+	atomic.StoreInt32(&cfg.paused, 0)
+}
+
+// IsPaused tells whether pruning is currently suspended.
+func (cfg *PruningConfig) IsPaused() bool {
+	return atomic.LoadInt32(&cfg.paused) != 0
+}
+
+// Metrics returns a snapshot of the cumulative pruning metrics.
+func (cfg *PruningConfig) Metrics() PruningMetrics {
+	return PruningMetrics{
+		BytesReclaimed:  atomic.LoadUint64(&cfg.metrics.BytesReclaimed),
+		AddressesPurged: atomic.LoadUint64(&cfg.metrics.AddressesPurged),
+	}
+}
+
+func (cfg *PruningConfig) addBytesReclaimed(n uint64) {
+	atomic.AddUint64(&cfg.metrics.BytesReclaimed, n)
+}
+
+func (cfg *PruningConfig) addAddressesPurged(n uint64) {
+	atomic.AddUint64(&cfg.metrics.AddressesPurged, n)
+}
+
+// pruningConfig is the process-wide pruning policy. It is nil until Configure runs, so that
+// package initialization (which happens before pflag/viper have parsed the CLI flags and config
+// file) never has a chance to read the CfgPruningMode/CfgPruningTxLookupLimit parameter defaults
+// instead of an operator's actual values.
+var pruningConfig *PruningConfig
+
+// Configure loads pruningConfig from the CfgPruningMode/CfgPruningTxLookupLimit config
+// parameters and registers the hot-reload callback that keeps it in sync; see loadPruningConfig
+// in parameters.go. It must be called from the plugin's configure hook, after the node's config
+// parameters have been parsed, and before pruneDatabase or any other pruningConfig access runs.
+func Configure() {
+	pruningConfig = loadPruningConfig()
+}
@@ -0,0 +1,436 @@
+package snapshot
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/gohornet/hornet/packages/model/milestone_index"
+	"github.com/gohornet/hornet/packages/model/tangle"
+	"github.com/gohornet/hornet/pkg/peering/peer"
+	"github.com/gohornet/hornet/pkg/protocol/snap"
+)
+
+// maxInFlightChunksPerPeer bounds how many requests SnapSyncer keeps outstanding against a
+// single peer at once, so a slow peer can't have an unbounded number of responses queued up.
+const maxInFlightChunksPerPeer = 4
+
+// maxAddressShards bounds how many address-range shards fetchAddresses walks concurrently, so a
+// large peer set doesn't explode into an unreasonable number of tiny concurrent walks.
+const maxAddressShards = 16
+
+var (
+	// ErrSnapSyncAborted is returned when the abort signal fires while a sync is in progress.
+	ErrSnapSyncAborted = errors.New("snap-sync aborted")
+	// ErrNoSnapshotManifestAvailable is returned when no peer offered a usable manifest.
+	ErrNoSnapshotManifestAvailable = errors.New("no peer offered a snapshot manifest")
+	// ErrChunkDigestMismatch is returned when the seeded address balances don't hash to the
+	// digest promised by the manifest, i.e. at least one peer served corrupt or foreign data.
+	ErrChunkDigestMismatch = errors.New("address chunk digest does not match manifest")
+)
+
+// syncState is the state of the SnapSyncer state machine.
+type syncState byte
+
+const (
+	syncStateManifest syncState = iota
+	syncStateAddresses
+	syncStateMilestoneDiffs
+	syncStateSeeding
+	syncStateDone
+	syncStateFailed
+)
+
+// SnapTransport is the peer-facing half of snap-sync: sending a snap protocol request and
+// waiting for the matching response. It is implemented on top of the processor/peer request-reply
+// plumbing, kept behind an interface here so SnapSyncer's pipelining and verification logic can
+// be exercised independently of the network layer.
+type SnapTransport interface {
+	GetSnapshotManifest(p *peer.Peer) (*snap.SnapshotManifest, error)
+	GetAddressRange(p *peer.Peer, req *snap.GetAddressRangeRequest) (*snap.AddressRangeResponse, error)
+	GetMilestoneDiffs(p *peer.Peer, req *snap.GetMilestoneDiffsRequest) ([]*snap.MilestoneDiffResponse, error)
+}
+
+// SnapSyncer pipelines GetSnapshotManifest/GetAddressRange/GetMilestoneDiffs requests against
+// a set of peers, verifies every chunk it receives against the agreed-upon manifest digest, and
+// seeds the local database so that pruneDatabase can resume from the delivered pruning index.
+type SnapSyncer struct {
+	transport SnapTransport
+	peers     []*peer.Peer
+
+	mu       sync.Mutex
+	state    syncState
+	inFlight map[string]int
+	err      error
+
+	manifest *snap.SnapshotManifest
+
+	addressesMu sync.Mutex
+	addresses   []snap.AddressBalance
+
+	milestoneDiffsMu sync.Mutex
+	milestoneDiffs   map[uint32][]byte
+}
+
+// NewSnapSyncer creates a SnapSyncer that will pipeline snap-sync requests against peers
+// using the given transport.
+func NewSnapSyncer(peers []*peer.Peer, transport SnapTransport) *SnapSyncer {
+	return &SnapSyncer{
+		transport:      transport,
+		peers:          peers,
+		state:          syncStateManifest,
+		inFlight:       make(map[string]int),
+		milestoneDiffs: make(map[uint32][]byte),
+	}
+}
+
+// State returns the current state of the sync pipeline.
+func (s *SnapSyncer) State() syncState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state
+}
+
+// Start runs the sync pipeline to completion (or until abortSignal fires) and, on success,
+// seeds the local database so pruneDatabase can resume pruning from the delivered index.
+func (s *SnapSyncer) Start(abortSignal <-chan struct{}) error {
+	if err := s.fetchManifest(abortSignal); err != nil {
+		return s.fail(err)
+	}
+
+	if err := s.fetchAddresses(abortSignal); err != nil {
+		return s.fail(err)
+	}
+
+	if err := s.verifyAddresses(); err != nil {
+		return s.fail(err)
+	}
+
+	if err := s.fetchMilestoneDiffs(abortSignal); err != nil {
+		return s.fail(err)
+	}
+
+	if err := s.seed(); err != nil {
+		return s.fail(err)
+	}
+
+	s.setState(syncStateDone)
+	return nil
+}
+
+func (s *SnapSyncer) fail(err error) error {
+	s.mu.Lock()
+	s.state = syncStateFailed
+	s.err = err
+	s.mu.Unlock()
+	return err
+}
+
+func (s *SnapSyncer) setState(state syncState) {
+	s.mu.Lock()
+	s.state = state
+	s.mu.Unlock()
+}
+
+// fetchManifest requests the snapshot manifest from every candidate peer and picks the most
+// recent one (highest pruning index) as the manifest to sync against.
+func (s *SnapSyncer) fetchManifest(abortSignal <-chan struct{}) error {
+	var best *snap.SnapshotManifest
+
+	for _, p := range s.peers {
+		select {
+		case <-abortSignal:
+			return ErrSnapSyncAborted
+		default:
+		}
+
+		manifest, err := s.transport.GetSnapshotManifest(p)
+		if err != nil {
+			log.Warnf("snap-sync: GetSnapshotManifest from %s failed: %v", p.ID, err)
+			continue
+		}
+
+		if best == nil || manifest.PruningIndex > best.PruningIndex {
+			best = manifest
+		}
+	}
+
+	if best == nil {
+		return ErrNoSnapshotManifestAvailable
+	}
+
+	s.manifest = best
+	s.setState(syncStateAddresses)
+	return nil
+}
+
+// addressShardBound is a contiguous, non-overlapping slice of the address keyspace walked by one
+// fetchAddressRange call. Shards are ordered so concatenating their results in order reproduces
+// the full ascending address sequence.
+type addressShardBound struct {
+	from, to []byte
+}
+
+// addressShardBounds splits the full address keyspace into shardCount contiguous shards by
+// leading byte, so fetchAddresses can walk all of them concurrently against different peers.
+func addressShardBounds(shardCount int) []addressShardBound {
+	if shardCount < 1 {
+		shardCount = 1
+	}
+	if shardCount > 256 {
+		shardCount = 256
+	}
+
+	step := 256 / shardCount
+	bounds := make([]addressShardBound, 0, shardCount)
+	for i := 0; i < shardCount; i++ {
+		from := []byte{byte(i * step)}
+		var to []byte
+		if i < shardCount-1 {
+			to = []byte{byte((i + 1) * step)}
+		}
+		bounds = append(bounds, addressShardBound{from: from, to: to})
+	}
+	return bounds
+}
+
+// fetchAddresses pipelines GetAddressRange requests concurrently across shardCount disjoint
+// address-keyspace shards (shardCount scaling with the available peers/backpressure budget),
+// each shard walked to completion against whichever peer has a free in-flight slot.
+func (s *SnapSyncer) fetchAddresses(abortSignal <-chan struct{}) error {
+	shardCount := len(s.peers) * maxInFlightChunksPerPeer
+	if shardCount > maxAddressShards {
+		shardCount = maxAddressShards
+	}
+	bounds := addressShardBounds(shardCount)
+
+	results := make([][]snap.AddressBalance, len(bounds))
+	errs := make([]error, len(bounds))
+
+	var wg sync.WaitGroup
+	for i, bound := range bounds {
+		wg.Add(1)
+		go func(i int, bound addressShardBound) {
+			defer wg.Done()
+			addrs, err := s.fetchAddressShard(abortSignal, bound.from, bound.to)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			results[i] = addrs
+		}(i, bound)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	var all []snap.AddressBalance
+	for _, addrs := range results {
+		all = append(all, addrs...)
+	}
+
+	s.addressesMu.Lock()
+	s.addresses = all
+	s.addressesMu.Unlock()
+
+	s.setState(syncStateMilestoneDiffs)
+	return nil
+}
+
+// fetchAddressShard walks the given [from, to) shard of the address keyspace to completion,
+// claiming a peer slot (up to maxInFlightChunksPerPeer per peer) for each request.
+func (s *SnapSyncer) fetchAddressShard(abortSignal <-chan struct{}, from, to []byte) ([]snap.AddressBalance, error) {
+	var addrs []snap.AddressBalance
+
+	for {
+		select {
+		case <-abortSignal:
+			return nil, ErrSnapSyncAborted
+		default:
+		}
+
+		p := s.nextAvailablePeer()
+		if p == nil {
+			// every peer is at its in-flight cap; back off briefly before retrying.
+			time.Sleep(50 * time.Millisecond)
+			continue
+		}
+
+		s.markInFlight(p, 1)
+		resp, err := s.transport.GetAddressRange(p, &snap.GetAddressRangeRequest{From: from, To: to})
+		s.markInFlight(p, -1)
+		if err != nil {
+			log.Warnf("snap-sync: GetAddressRange from %s failed: %v", p.ID, err)
+			continue
+		}
+
+		addrs = append(addrs, resp.Addresses...)
+		if resp.Exhausted || len(resp.Addresses) == 0 {
+			return addrs, nil
+		}
+		from = snap.NextAddress(resp.Addresses[len(resp.Addresses)-1].Address)
+	}
+}
+
+// verifyAddresses checks the digest of every received address against the digest promised by
+// the manifest, so corrupt or foreign data from any single peer is caught before seeding. The
+// digest is re-derived by replaying the full, now-ordered address sequence through the same
+// canonical paging the offering side used to compute it, regardless of how fetchAddresses itself
+// happened to chunk its concurrent requests.
+func (s *SnapSyncer) verifyAddresses() error {
+	if snap.DigestAddressSequence(s.addresses) != s.manifest.Digest {
+		return ErrChunkDigestMismatch
+	}
+	return nil
+}
+
+// fetchMilestoneDiffs pipelines GetMilestoneDiffs requests for the manifest's ledger-diff range
+// across a pool of workers sized to the available peers/backpressure budget, each worker pulling
+// the next unclaimed batch and retrying it on failure (see fetchMilestoneDiffBatch).
+func (s *SnapSyncer) fetchMilestoneDiffs(abortSignal <-chan struct{}) error {
+	remaining := make([]uint32, 0, s.manifest.LedgerDiffRangeTo-s.manifest.LedgerDiffRangeFrom+1)
+	for i := s.manifest.LedgerDiffRangeFrom; i <= s.manifest.LedgerDiffRangeTo; i++ {
+		remaining = append(remaining, i)
+	}
+
+	var batches [][]uint32
+	for len(remaining) > 0 {
+		batchSize := maxInFlightChunksPerPeer
+		if batchSize > len(remaining) {
+			batchSize = len(remaining)
+		}
+		batches = append(batches, remaining[:batchSize])
+		remaining = remaining[batchSize:]
+	}
+
+	workerCount := len(s.peers) * maxInFlightChunksPerPeer
+	if workerCount < 1 {
+		workerCount = 1
+	}
+
+	batchCh := make(chan []uint32)
+	errCh := make(chan error, workerCount)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workerCount; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range batchCh {
+				if err := s.fetchMilestoneDiffBatch(abortSignal, batch); err != nil {
+					select {
+					case errCh <- err:
+					default:
+					}
+					return
+				}
+			}
+		}()
+	}
+
+sendLoop:
+	for _, batch := range batches {
+		select {
+		case batchCh <- batch:
+		case <-abortSignal:
+			break sendLoop
+		}
+	}
+	close(batchCh)
+	wg.Wait()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-abortSignal:
+		return ErrSnapSyncAborted
+	default:
+	}
+
+	s.setState(syncStateSeeding)
+	return nil
+}
+
+// fetchMilestoneDiffBatch fetches a single milestone-diff batch, retrying until it succeeds or the
+// abort signal fires. Each attempt goes to the first peer with a free in-flight slot, which can be
+// the same peer whose previous attempt failed.
+func (s *SnapSyncer) fetchMilestoneDiffBatch(abortSignal <-chan struct{}, batch []uint32) error {
+	for {
+		select {
+		case <-abortSignal:
+			return ErrSnapSyncAborted
+		default:
+		}
+
+		p := s.nextAvailablePeer()
+		if p == nil {
+			time.Sleep(50 * time.Millisecond)
+			continue
+		}
+
+		s.markInFlight(p, 1)
+		diffs, err := s.transport.GetMilestoneDiffs(p, &snap.GetMilestoneDiffsRequest{From: batch[0], To: batch[len(batch)-1]})
+		s.markInFlight(p, -1)
+		if err != nil {
+			log.Warnf("snap-sync: GetMilestoneDiffs from %s failed: %v", p.ID, err)
+			continue
+		}
+
+		s.milestoneDiffsMu.Lock()
+		for _, diff := range diffs {
+			s.milestoneDiffs[diff.MilestoneIndex] = diff.Diff
+		}
+		s.milestoneDiffsMu.Unlock()
+		return nil
+	}
+}
+
+// seed writes the verified solid entry points, address balances and ledger diffs into the local
+// database and updates the snapshot info, so pruneDatabase can resume from the delivered index.
+func (s *SnapSyncer) seed() error {
+	if err := tangle.SeedSolidEntryPoints(s.manifest.SolidEntryPoints); err != nil {
+		return err
+	}
+
+	if err := tangle.SeedAddressBalances(s.addresses); err != nil {
+		return err
+	}
+
+	for msIndex, diff := range s.milestoneDiffs {
+		if err := tangle.SeedLedgerDiff(milestone_index.MilestoneIndex(msIndex), diff); err != nil {
+			return err
+		}
+	}
+
+	snapshotInfo := tangle.GetSnapshotInfo()
+	if snapshotInfo == nil {
+		snapshotInfo = &tangle.SnapshotInfo{}
+	}
+	snapshotInfo.PruningIndex = milestone_index.MilestoneIndex(s.manifest.PruningIndex)
+	snapshotInfo.SnapshotIndex = milestone_index.MilestoneIndex(s.manifest.LedgerDiffRangeTo)
+	tangle.SetSnapshotInfo(snapshotInfo)
+
+	return nil
+}
+
+func (s *SnapSyncer) nextAvailablePeer() *peer.Peer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, p := range s.peers {
+		if s.inFlight[p.ID] < maxInFlightChunksPerPeer {
+			return p
+		}
+	}
+	return nil
+}
+
+func (s *SnapSyncer) markInFlight(p *peer.Peer, delta int) {
+	s.mu.Lock()
+	s.inFlight[p.ID] += delta
+	s.mu.Unlock()
+}
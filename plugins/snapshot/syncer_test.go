@@ -0,0 +1,44 @@
+package snapshot
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestAddressShardBoundsCoverFullKeyspaceContiguously(t *testing.T) {
+	for _, shardCount := range []int{1, 2, 3, 7, 16, 256, 300} {
+		bounds := addressShardBounds(shardCount)
+
+		if len(bounds[0].from) != 1 || bounds[0].from[0] != 0 {
+			t.Fatalf("shardCount=%d: first shard must start at the keyspace minimum, got %v", shardCount, bounds[0].from)
+		}
+		if to := bounds[len(bounds)-1].to; to != nil {
+			t.Fatalf("shardCount=%d: last shard must be open-ended (nil to), got %v", shardCount, to)
+		}
+
+		for i := 1; i < len(bounds); i++ {
+			if !bytes.Equal(bounds[i].from, bounds[i-1].to) {
+				t.Fatalf("shardCount=%d: shard %d does not start where shard %d ended: from=%v, prev to=%v",
+					shardCount, i, i-1, bounds[i].from, bounds[i-1].to)
+			}
+		}
+
+		for i := 1; i < len(bounds); i++ {
+			if bytes.Compare(bounds[i].from, bounds[i-1].from) <= 0 {
+				t.Fatalf("shardCount=%d: shard bounds are not strictly ascending at index %d", shardCount, i)
+			}
+		}
+	}
+}
+
+func TestAddressShardBoundsClampsShardCount(t *testing.T) {
+	if got := len(addressShardBounds(0)); got != 1 {
+		t.Fatalf("addressShardBounds(0) returned %d shards, want 1", got)
+	}
+	if got := len(addressShardBounds(-5)); got != 1 {
+		t.Fatalf("addressShardBounds(-5) returned %d shards, want 1", got)
+	}
+	if got := len(addressShardBounds(1000)); got != 256 {
+		t.Fatalf("addressShardBounds(1000) returned %d shards, want 256 (clamped)", got)
+	}
+}
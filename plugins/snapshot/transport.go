@@ -0,0 +1,98 @@
+package snapshot
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gohornet/hornet/pkg/peering/peer"
+	"github.com/gohornet/hornet/pkg/protocol/processor"
+	"github.com/gohornet/hornet/pkg/protocol/snap"
+)
+
+// snapReplyTimeout bounds how long ProcessorSnapTransport waits for a peer to answer a single
+// snap protocol request, so a peer that goes silent mid-sync doesn't wedge the syncer forever.
+const snapReplyTimeout = 30 * time.Second
+
+// ErrSnapReplyTimeout is returned when a peer doesn't answer a snap protocol request in time.
+var ErrSnapReplyTimeout = errors.New("timed out waiting for snap protocol reply")
+
+// ProcessorSnapTransport implements SnapTransport on top of a Processor's snap protocol reply
+// correlation (Processor.AwaitSnapReply/deliverSnapReply), so SnapSyncer can actually exchange
+// GetSnapshotManifest/GetAddressRange/GetMilestoneDiffs requests and replies with real peers
+// instead of only an interface with nothing behind it.
+type ProcessorSnapTransport struct {
+	proc *processor.Processor
+}
+
+// NewProcessorSnapTransport creates a SnapTransport that sends snap protocol requests to peers
+// and correlates their replies via proc.
+func NewProcessorSnapTransport(proc *processor.Processor) *ProcessorSnapTransport {
+	return &ProcessorSnapTransport{proc: proc}
+}
+
+// GetSnapshotManifest implements SnapTransport.
+func (t *ProcessorSnapTransport) GetSnapshotManifest(p *peer.Peer) (*snap.SnapshotManifest, error) {
+	msg, err := snap.NewGetSnapshotManifestMessage()
+	if err != nil {
+		return nil, err
+	}
+
+	replyCh := t.proc.AwaitSnapReply(p)
+	p.EnqueueForSending(msg)
+
+	select {
+	case data := <-replyCh:
+		return snap.ParseSnapshotManifest(data)
+	case <-time.After(snapReplyTimeout):
+		return nil, ErrSnapReplyTimeout
+	}
+}
+
+// GetAddressRange implements SnapTransport.
+func (t *ProcessorSnapTransport) GetAddressRange(p *peer.Peer, req *snap.GetAddressRangeRequest) (*snap.AddressRangeResponse, error) {
+	msg, err := snap.NewGetAddressRangeMessage(req)
+	if err != nil {
+		return nil, err
+	}
+
+	replyCh := t.proc.AwaitSnapReply(p)
+	p.EnqueueForSending(msg)
+
+	select {
+	case data := <-replyCh:
+		return snap.ParseAddressRangeResponse(data)
+	case <-time.After(snapReplyTimeout):
+		return nil, ErrSnapReplyTimeout
+	}
+}
+
+// GetMilestoneDiffs implements SnapTransport. It reads MilestoneDiffs messages until the
+// offering side's snap.MilestoneDiffsTerminator, registering a fresh AwaitSnapReply before each
+// one so the correlation queue always has the next expected reply's channel waiting.
+func (t *ProcessorSnapTransport) GetMilestoneDiffs(p *peer.Peer, req *snap.GetMilestoneDiffsRequest) ([]*snap.MilestoneDiffResponse, error) {
+	msg, err := snap.NewGetMilestoneDiffsMessage(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var diffs []*snap.MilestoneDiffResponse
+	replyCh := t.proc.AwaitSnapReply(p)
+	p.EnqueueForSending(msg)
+
+	for {
+		select {
+		case data := <-replyCh:
+			diff, err := snap.ParseMilestoneDiffResponse(data)
+			if err != nil {
+				return nil, err
+			}
+			if diff.MilestoneIndex == snap.MilestoneDiffsTerminator {
+				return diffs, nil
+			}
+			diffs = append(diffs, diff)
+			replyCh = t.proc.AwaitSnapReply(p)
+		case <-time.After(snapReplyTimeout):
+			return nil, ErrSnapReplyTimeout
+		}
+	}
+}